@@ -0,0 +1,89 @@
+// 消息编解码器，支持在JSON/Gob之间按需切换。
+package teleport
+
+import (
+  "bytes"
+  "encoding/gob"
+  "encoding/json"
+)
+
+// 编解码器标识，写入包头供收发双方协商解码方式
+const (
+  codecJSON = iota + 1
+  codecGob
+)
+
+// Codec 定义NetData的编解码方式，TP.SetCodec替换的是发送端使用的实现；接收端按包头
+// 标识字节选择解码器，只识别JSONCodec与GobCodec，其他实现以JSON标识发送、由JSONCodec解码
+type Codec interface {
+  // Name 返回编解码器名称；收发双方的协商只依据包头标识字节，不使用Name
+  Name() string
+  Marshal(*NetData) ([]byte, error)
+  Unmarshal([]byte) (*NetData, error)
+}
+
+// codecTag 返回编解码器对应的包头标识字节
+func codecTag(c Codec) byte {
+  switch c.(type) {
+  case *GobCodec:
+    return codecGob
+  default:
+    return codecJSON
+  }
+}
+
+// codecByTag 依据包头标识字节返回对应编解码器，未知标识时回退到JSON
+func codecByTag(tag byte) Codec {
+  switch tag {
+  case codecGob:
+    return new(GobCodec)
+  default:
+    return new(JSONCodec)
+  }
+}
+
+// JSONCodec 以JSON传输数据，与包注释描述的传输格式一致；Body按其原始值编码，不强制转为
+// []byte/string，但解码到interface{}时得到JSON通用类型（如map[string]interface{}、float64），
+// 不还原原始Go类型
+type JSONCodec struct{}
+
+func (*JSONCodec) Name() string { return "json" }
+
+func (*JSONCodec) Marshal(data *NetData) ([]byte, error) {
+  return json.Marshal(data)
+}
+
+func (*JSONCodec) Unmarshal(b []byte) (*NetData, error) {
+  d := new(NetData)
+  if err := json.Unmarshal(b, d); err != nil {
+    return nil, err
+  }
+  return d, nil
+}
+
+// GobCodec 以gob传输数据，适合纯Go节点间通信，Body按原始Go类型往返；Body为其他自定义
+// 类型时须事先以gob.Register登记，否则Marshal返回错误
+type GobCodec struct{}
+
+func init() {
+  // IDENTITY握手的Body为*HandshakeRequest，登记后才能经GobCodec编解码
+  gob.Register(&HandshakeRequest{})
+}
+
+func (*GobCodec) Name() string { return "gob" }
+
+func (*GobCodec) Marshal(data *NetData) ([]byte, error) {
+  buf := new(bytes.Buffer)
+  if err := gob.NewEncoder(buf).Encode(data); err != nil {
+    return nil, err
+  }
+  return buf.Bytes(), nil
+}
+
+func (*GobCodec) Unmarshal(b []byte) (*NetData, error) {
+  d := new(NetData)
+  if err := gob.NewDecoder(bytes.NewReader(b)).Decode(d); err != nil {
+    return nil, err
+  }
+  return d, nil
+}
@@ -0,0 +1,57 @@
+// 单个socket连接的包装：收发缓存通道与增量读取缓冲。
+package teleport
+
+import (
+  "bufio"
+  "net"
+  "sync"
+  "sync/atomic"
+)
+
+// Connect 包装一次socket连接及其收发状态
+type Connect struct {
+  net.Conn
+  // 节点唯一标识符，身份识别前为host:port
+  UID string
+  // 是否为一次性短连接
+  IsShort bool
+  // 连接是否已完成身份识别，可正常收发业务数据
+  ready int32
+  // 发送缓存通道
+  WriteChan chan *NetData
+  // 带缓冲的读取器，大小取自TP.connBufferLen，供Framer增量解帧，避免TmpBuffer式O(n²)拼接
+  reader *bufio.Reader
+  // IDENTITY握手期间签发给对端的挑战nonce，验证通过或连接关闭后即失效
+  pendingNonce string
+  // 保证Close在closeConn与Close/Shutdown并发争用同一连接时只真正执行一次
+  closeOnce sync.Once
+}
+
+// NewConnect 包装一个已建立的连接，wChanCap为发送缓存通道长度，bufferLen为读取缓冲区大小
+func NewConnect(conn net.Conn, wChanCap, bufferLen int) *Connect {
+  return &Connect{
+    Conn:      conn,
+    WriteChan: make(chan *NetData, wChanCap),
+    reader:    bufio.NewReaderSize(conn, bufferLen),
+  }
+}
+
+// IsReady 返回连接是否已完成身份识别，可正常通信
+func (self *Connect) IsReady() bool {
+  return atomic.LoadInt32(&self.ready) == 1
+}
+
+// SetReady 标记连接为就绪状态
+func (self *Connect) SetReady() {
+  atomic.StoreInt32(&self.ready, 1)
+}
+
+// Close 关闭发送通道与底层连接；并发调用只有一次真正生效，其余直接返回nil
+func (self *Connect) Close() error {
+  var err error
+  self.closeOnce.Do(func() {
+    close(self.WriteChan)
+    err = self.Conn.Close()
+  })
+  return err
+}
@@ -0,0 +1,100 @@
+// 并发安全的节点连接表：accept循环、心跳协程、apiHandle与Close可同时读写。
+package teleport
+
+import (
+  "sort"
+  "sync"
+)
+
+// ConnPool 是并发安全的uid到Connect映射
+type ConnPool struct {
+  mu    sync.RWMutex
+  conns map[string]*Connect
+}
+
+func newConnPool() *ConnPool {
+  return &ConnPool{conns: make(map[string]*Connect)}
+}
+
+// get 返回uid对应的连接，不存在时ok为false
+func (self *ConnPool) get(uid string) (*Connect, bool) {
+  self.mu.RLock()
+  defer self.mu.RUnlock()
+  conn, ok := self.conns[uid]
+  return conn, ok
+}
+
+// set 登记或替换一个连接
+func (self *ConnPool) set(uid string, conn *Connect) {
+  self.mu.Lock()
+  defer self.mu.Unlock()
+  self.conns[uid] = conn
+}
+
+// delete 移除一个连接
+func (self *ConnPool) delete(uid string) {
+  self.mu.Lock()
+  defer self.mu.Unlock()
+  delete(self.conns, uid)
+}
+
+// rename 身份识别完成后，将连接从host:port键迁移为UID键
+func (self *ConnPool) rename(oldKey, newKey string) {
+  self.mu.Lock()
+  defer self.mu.Unlock()
+  if conn, ok := self.conns[oldKey]; ok {
+    delete(self.conns, oldKey)
+    self.conns[newKey] = conn
+  }
+}
+
+// nodes 返回当前全部节点uid的快照
+func (self *ConnPool) nodes() []string {
+  self.mu.RLock()
+  defer self.mu.RUnlock()
+  uids := make([]string, 0, len(self.conns))
+  for uid := range self.conns {
+    uids = append(uids, uid)
+  }
+  return uids
+}
+
+// readyConns 返回当前全部就绪连接按UID排序的快照，供Selector使用；连接集合不变时
+// 同一下标总对应同一连接
+func (self *ConnPool) readyConns() []*Connect {
+  self.mu.RLock()
+  defer self.mu.RUnlock()
+  conns := make([]*Connect, 0, len(self.conns))
+  for _, conn := range self.conns {
+    if conn.IsReady() {
+      conns = append(conns, conn)
+    }
+  }
+  sort.Slice(conns, func(i, j int) bool { return conns[i].UID < conns[j].UID })
+  return conns
+}
+
+// count 返回节点总数；onlyReady为true时只统计已就绪的连接
+func (self *ConnPool) count(onlyReady bool) int {
+  self.mu.RLock()
+  defer self.mu.RUnlock()
+  if !onlyReady {
+    return len(self.conns)
+  }
+  n := 0
+  for _, conn := range self.conns {
+    if conn.IsReady() {
+      n++
+    }
+  }
+  return n
+}
+
+// drain 清空连接表并返回清空前的快照，供Close/Shutdown逐个关闭
+func (self *ConnPool) drain() map[string]*Connect {
+  self.mu.Lock()
+  defer self.mu.Unlock()
+  snapshot := self.conns
+  self.conns = make(map[string]*Connect)
+  return snapshot
+}
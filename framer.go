@@ -0,0 +1,153 @@
+// 粘包/拆包处理：可插拔的帧编解码策略，替代原先固定的henrylee2cn包头方案。
+package teleport
+
+import (
+  "bufio"
+  "bytes"
+  "encoding/binary"
+  "errors"
+  "io"
+)
+
+// 默认单帧最大长度，防止恶意或错误的长度字段耗尽内存
+const defaultMaxFrameSize = 4 << 20 // 4MB
+
+var errFrameTooLarge = errors.New("teleport: 帧长度超过MaxFrameSize限制")
+
+// Framer 定义粘包/拆包的封帧与拆帧策略，TP.SetFramer可替换默认实现
+type Framer interface {
+  // Name 返回帧格式名称
+  Name() string
+  // WriteFrame 将payload封装为一帧并写入w
+  WriteFrame(w io.Writer, payload []byte) error
+  // ReadFrame 从r中读取下一帧完整数据，数据不足一帧时阻塞等待
+  ReadFrame(r *bufio.Reader) ([]byte, error)
+}
+
+// LengthPrefixFramer 使用4字节大端长度前缀分帧，取代原henrylee2cn包头方案。
+// Header非空时在长度前额外写入固定魔数串，用于协议探测，兼容SetPackHeader的历史用法
+type LengthPrefixFramer struct {
+  Header       string
+  MaxFrameSize int
+}
+
+// NewLengthPrefixFramer 创建长度前缀分帧器，maxFrameSize<=0时使用4MB默认上限
+func NewLengthPrefixFramer(maxFrameSize int) *LengthPrefixFramer {
+  if maxFrameSize <= 0 {
+    maxFrameSize = defaultMaxFrameSize
+  }
+  return &LengthPrefixFramer{MaxFrameSize: maxFrameSize}
+}
+
+func (self *LengthPrefixFramer) Name() string { return "length-prefix" }
+
+func (self *LengthPrefixFramer) WriteFrame(w io.Writer, payload []byte) error {
+  buf := new(bytes.Buffer)
+  if self.Header != "" {
+    buf.WriteString(self.Header)
+  }
+  if err := binary.Write(buf, binary.BigEndian, uint32(len(payload))); err != nil {
+    return err
+  }
+  buf.Write(payload)
+  _, err := w.Write(buf.Bytes())
+  return err
+}
+
+func (self *LengthPrefixFramer) ReadFrame(r *bufio.Reader) ([]byte, error) {
+  if self.Header != "" {
+    header := make([]byte, len(self.Header))
+    if _, err := io.ReadFull(r, header); err != nil {
+      return nil, err
+    }
+    if string(header) != self.Header {
+      return nil, errors.New("teleport: 包头校验失败")
+    }
+  }
+
+  lenBuf := make([]byte, 4)
+  if _, err := io.ReadFull(r, lenBuf); err != nil {
+    return nil, err
+  }
+
+  size := binary.BigEndian.Uint32(lenBuf)
+  max := self.MaxFrameSize
+  if max <= 0 {
+    max = defaultMaxFrameSize
+  }
+  if int(size) > max {
+    return nil, errFrameTooLarge
+  }
+
+  payload := make([]byte, size)
+  if _, err := io.ReadFull(r, payload); err != nil {
+    return nil, err
+  }
+  return payload, nil
+}
+
+// fixedLengthHeader 是FixedLengthFramer记录头部大端长度前缀的字节数
+const fixedLengthHeader = 4
+
+// FixedLengthFramer 每帧固定Size字节：前4字节为大端长度前缀，其余为payload，
+// 不足Size的部分补零；payload加上长度前缀超出Size则报错，而非静默截断/补零
+type FixedLengthFramer struct {
+  Size int
+}
+
+func NewFixedLengthFramer(size int) *FixedLengthFramer {
+  return &FixedLengthFramer{Size: size}
+}
+
+func (self *FixedLengthFramer) Name() string { return "fixed-length" }
+
+func (self *FixedLengthFramer) WriteFrame(w io.Writer, payload []byte) error {
+  if len(payload)+fixedLengthHeader > self.Size {
+    return errors.New("teleport: payload长度超出FixedLengthFramer.Size")
+  }
+  frame := make([]byte, self.Size)
+  binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+  copy(frame[fixedLengthHeader:], payload)
+  _, err := w.Write(frame)
+  return err
+}
+
+func (self *FixedLengthFramer) ReadFrame(r *bufio.Reader) ([]byte, error) {
+  frame := make([]byte, self.Size)
+  if _, err := io.ReadFull(r, frame); err != nil {
+    return nil, err
+  }
+  size := binary.BigEndian.Uint32(frame)
+  if int(size) > self.Size-fixedLengthHeader {
+    return nil, errors.New("teleport: FixedLengthFramer记录中的长度前缀已损坏")
+  }
+  return frame[fixedLengthHeader : fixedLengthHeader+int(size)], nil
+}
+
+// DelimiterFramer 以单字节分隔符（如'\n'）终止一帧，payload中不得包含该分隔符
+type DelimiterFramer struct {
+  Delim byte
+}
+
+func NewDelimiterFramer(delim byte) *DelimiterFramer {
+  return &DelimiterFramer{Delim: delim}
+}
+
+func (self *DelimiterFramer) Name() string { return "delimiter" }
+
+func (self *DelimiterFramer) WriteFrame(w io.Writer, payload []byte) error {
+  if bytes.IndexByte(payload, self.Delim) != -1 {
+    return errors.New("teleport: payload中包含分隔符，DelimiterFramer无法安全封帧")
+  }
+  frame := append(append([]byte{}, payload...), self.Delim)
+  _, err := w.Write(frame)
+  return err
+}
+
+func (self *DelimiterFramer) ReadFrame(r *bufio.Reader) ([]byte, error) {
+  frame, err := r.ReadBytes(self.Delim)
+  if err != nil {
+    return nil, err
+  }
+  return frame[:len(frame)-1], nil
+}
@@ -0,0 +1,83 @@
+package teleport
+
+import (
+  "net"
+  "testing"
+  "time"
+)
+
+// newPipedTP构造一对通过net.Pipe()互连、共享同一预共享密钥的TP实例，并各自在
+// connPool中以给定的本地记账键登记对端连接，模拟一条已建立socket但尚未完成
+// IDENTITY握手的连接，驱动apiHandle/readLoop/writeLoop跑起来
+func newPipedTP(t *testing.T, uidA, uidB, authKey, rawKeyA, rawKeyB string) (a, b *TP) {
+  t.Helper()
+  connA, connB := net.Pipe()
+
+  a = New().(*TP)
+  a.uid = uidA
+  a.authKey = []byte(authKey)
+  a.reserveAPI()
+
+  b = New().(*TP)
+  b.uid = uidB
+  b.authKey = []byte(authKey)
+  b.reserveAPI()
+
+  wrappedA := NewConnect(connA, a.connWChanCap, a.connBufferLen)
+  wrappedA.UID = rawKeyA
+  a.connPool.set(rawKeyA, wrappedA)
+
+  wrappedB := NewConnect(connB, b.connWChanCap, b.connBufferLen)
+  wrappedB.UID = rawKeyB
+  b.connPool.set(rawKeyB, wrappedB)
+
+  go a.apiHandle()
+  go a.readLoop(wrappedA)
+  go a.writeLoop(wrappedA)
+
+  go b.apiHandle()
+  go b.readLoop(wrappedB)
+  go b.writeLoop(wrappedB)
+
+  return a, b
+}
+
+// TestIdentityHandshakeReachesReady驱动两个真实identity.Process实例完整走完一轮
+// 三步HMAC挑战/应答：A向B发起挑战，B计算Proof回送，A校验通过后应将其对B的连接
+// 提升为就绪
+func TestIdentityHandshakeReachesReady(t *testing.T) {
+  a, _ := newPipedTP(t, "nodeA", "nodeB", "s3cr3t", "raw-a", "raw-b")
+
+  // 由A自行触发一轮IDENTITY挑战，模拟accept/dial成功后的首个握手消息
+  a.apiReadChan <- NewNetData("", "raw-a", IDENTITY, nil)
+
+  deadline := time.Now().Add(2 * time.Second)
+  for time.Now().Before(deadline) {
+    if conn, ok := a.connPool.get("nodeB"); ok && conn.IsReady() {
+      return
+    }
+    time.Sleep(10 * time.Millisecond)
+  }
+  t.Fatal("A在超时前未能将与B的连接提升为就绪")
+}
+
+// TestIdentityHandshakeRejectsWrongKey验证预共享密钥不一致时，校验方不会提升
+// 连接为就绪，并会关闭该连接
+func TestIdentityHandshakeRejectsWrongKey(t *testing.T) {
+  a, b := newPipedTP(t, "nodeA", "nodeB", "s3cr3t", "raw-a", "raw-b")
+  b.authKey = []byte("wrong-key")
+
+  a.apiReadChan <- NewNetData("", "raw-a", IDENTITY, nil)
+
+  deadline := time.Now().Add(500 * time.Millisecond)
+  for time.Now().Before(deadline) {
+    if conn, ok := a.connPool.get("nodeB"); ok && conn.IsReady() {
+      t.Fatal("预共享密钥不一致，连接本不应被提升为就绪")
+    }
+    time.Sleep(10 * time.Millisecond)
+  }
+
+  if _, ok := a.connPool.get("raw-a"); ok {
+    t.Fatal("校验失败后本端连接应已被closeConn从connPool中移除")
+  }
+}
@@ -0,0 +1,84 @@
+// Handle的中间件链：在不修改各业务Handler的前提下组合日志、恢复、超时、权限等横切逻辑。
+package teleport
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "time"
+)
+
+// Middleware 包装一个Handle，返回附加了额外行为的新Handle
+type Middleware func(Handle) Handle
+
+// HandleFunc 让普通函数满足Handle接口，便于以函数字面量编写中间件
+type HandleFunc func(*NetData) *NetData
+
+func (f HandleFunc) Process(req *NetData) *NetData {
+  return f(req)
+}
+
+// Use 按注册顺序追加中间件，先注册者包裹在外层、最先执行
+func (self *TP) Use(mws ...Middleware) Teleport {
+  self.middlewares = append(self.middlewares, mws...)
+  return self
+}
+
+// wrapHandle 依注册顺序由外而内包装handle
+func (self *TP) wrapHandle(handle Handle) Handle {
+  for i := len(self.middlewares) - 1; i >= 0; i-- {
+    handle = self.middlewares[i](handle)
+  }
+  return handle
+}
+
+// RecoverMiddleware 捕获Handle.Process内部的panic，避免单个请求拖垮整个进程，
+// 默认已注册在TP.middlewares中
+func RecoverMiddleware() Middleware {
+  return func(next Handle) Handle {
+    return HandleFunc(func(req *NetData) (resp *NetData) {
+      defer func() {
+        if r := recover(); r != nil {
+          log.Printf("API处理发生panic：%v，operation=%v", r, req.Operation)
+          resp = ReturnError(req, FAILURE, fmt.Sprintf("服务器内部错误：%v", r))
+        }
+      }()
+      return next.Process(req)
+    })
+  }
+}
+
+// DeadlineMiddleware 为每次处理注入带超时的context.Context，可通过NetData.Context()获取，
+// 一般配合self.timeout使用：self.Use(DeadlineMiddleware(self.timeout))
+func DeadlineMiddleware(timeout time.Duration) Middleware {
+  return func(next Handle) Handle {
+    return HandleFunc(func(req *NetData) *NetData {
+      ctx, cancel := context.WithTimeout(context.Background(), timeout)
+      defer cancel()
+      req.WithContext(ctx)
+      return next.Process(req)
+    })
+  }
+}
+
+// ACLMiddleware 按NetData.From对各操作符进行白名单校验，allow中未出现的操作符不受限制
+func ACLMiddleware(allow map[string][]string) Middleware {
+  return func(next Handle) Handle {
+    return HandleFunc(func(req *NetData) *NetData {
+      whitelist, limited := allow[req.Operation]
+      if limited {
+        permitted := false
+        for _, uid := range whitelist {
+          if uid == req.From {
+            permitted = true
+            break
+          }
+        }
+        if !permitted {
+          return ReturnError(req, LLLEGAL, "节点（"+req.From+"）无权调用该API（"+req.Operation+"）")
+        }
+      }
+      return next.Process(req)
+    })
+  }
+}
@@ -2,7 +2,7 @@
 package teleport
 
 import (
-  "github.com/golang/protobuf/proto"
+  "context"
 )
 
 const (
@@ -28,6 +28,10 @@ type NetData struct {
   To string
   // 返回状态
   Status int
+  // 处理该请求时使用的上下文，由中间件（如DeadlineMiddleware）注入，不参与编解码
+  ctx context.Context
+  // 可选的一致性哈希路由键，留空时ConsistentHashSelector退化为按Operation哈希
+  RouteKey string
 }
 
 func NewNetData(from, to, operation string, body interface{}) *NetData {
@@ -40,22 +44,15 @@ func NewNetData(from, to, operation string, body interface{}) *NetData {
   }
 }
 
-func ProtoNetData2(data []byte, conn *Connect) (*NetData, error) {
-  d := new(NetData)
-  protoNetData := new(NetDataProto)
-  err := proto.Unmarshal(data, protoNetData)
-  if err == nil {
-    if d.From == "" {
-      d.From = conn.UID
-    }
-
-    d.Body = protoNetData.GetBody()
-    d.Operation = protoNetData.GetOperation()
-    d.UID = protoNetData.GetUID()
-    d.From = protoNetData.GetFrom()
-    d.To = protoNetData.GetTo()
-    d.Status = int(protoNetData.GetStatus())
+// Context 返回处理该请求时关联的上下文，未被中间件设置时返回context.Background()
+func (self *NetData) Context() context.Context {
+  if self.ctx == nil {
+    return context.Background()
   }
+  return self.ctx
+}
 
-  return d, err
+// WithContext 为该请求关联一个上下文，供中间件传递超时、取消等信号
+func (self *NetData) WithContext(ctx context.Context) {
+  self.ctx = ctx
 }
@@ -0,0 +1,208 @@
+// CLIENT模式断线重连：指数退避策略与按节点缓存的待发消息队列。
+package teleport
+
+import (
+  "context"
+  "crypto/tls"
+  "log"
+  "math/rand"
+  "net"
+  "sync"
+  "time"
+)
+
+// ReconnectPolicy 描述CLIENT模式下断线重连的退避策略
+type ReconnectPolicy struct {
+  // Base 首次重试前等待时间的基数，计入抖动后实际等待为其50%~100%
+  Base time.Duration
+  // Factor 每次重试等待时间的增长倍数
+  Factor float64
+  // MaxDelay 重试等待时间的上限
+  MaxDelay time.Duration
+  // MaxAttempts 最大重试次数，<=0表示不限制
+  MaxAttempts int
+}
+
+// DefaultReconnectPolicy 返回默认重连策略：500ms起步，指数退避，抖动，封顶30秒，不限次数
+func DefaultReconnectPolicy() *ReconnectPolicy {
+  return &ReconnectPolicy{
+    Base:     500 * time.Millisecond,
+    Factor:   2,
+    MaxDelay: 30 * time.Second,
+  }
+}
+
+// delay 返回第attempt次重试（从0开始计）应等待的时间：Base×Factor^attempt并封顶MaxDelay，
+// 再乘以[0.5,1)的随机抖动系数以避免重连风暴
+func (self *ReconnectPolicy) delay(attempt int) time.Duration {
+  d := float64(self.Base)
+  for i := 0; i < attempt; i++ {
+    d *= self.Factor
+  }
+  if max := float64(self.MaxDelay); self.MaxDelay > 0 && d > max {
+    d = max
+  }
+  jitter := d * (0.5 + rand.Float64()*0.5)
+  return time.Duration(jitter)
+}
+
+// SetReconnect 设置CLIENT模式下的断线重连策略；短连接模式(canClose)不参与重连
+func (self *TP) SetReconnect(policy *ReconnectPolicy) Teleport {
+  self.reconnect = policy
+  return self
+}
+
+// OnConnect 注册连接（含重连成功）就绪后触发的回调
+func (self *TP) OnConnect(fn func(uid string)) Teleport {
+  self.onConnect = fn
+  return self
+}
+
+// OnDisconnect 注册连接断开后触发的回调
+func (self *TP) OnDisconnect(fn func(uid string)) Teleport {
+  self.onDisconnect = fn
+  return self
+}
+
+// WaitReady 阻塞直到至少有一个就绪连接，或ctx被取消/超时，用以替代原先的busy-wait轮询
+func (self *TP) WaitReady(ctx context.Context) error {
+  for {
+    if self.CountNodes() > 0 {
+      return nil
+    }
+    select {
+    case <-ctx.Done():
+      return ctx.Err()
+    case <-time.After(50 * time.Millisecond):
+    }
+  }
+}
+
+// shouldReconnect 仅CLIENT长连接模式、且已配置重连策略时才参与断线重连
+func (self *TP) shouldReconnect() bool {
+  return self.mode == CLIENT && !self.canClose && self.reconnect != nil
+}
+
+// notifyDisconnect 连接断开后触发OnDisconnect回调
+func (self *TP) notifyDisconnect(uid string) {
+  if self.onDisconnect != nil {
+    self.onDisconnect(uid)
+  }
+}
+
+// flushPending 连接（重新）就绪后，将此前为该uid积压的待发消息写入其发送通道，
+// 并触发OnConnect回调
+func (self *TP) flushPending(uid string, conn *Connect) {
+  for _, data := range self.pending.flush(uid) {
+    conn.WriteChan <- data
+  }
+  // 未指定nodeuid、且当时没有就绪节点而积压在空uid下的消息，由首个就绪的连接补发
+  for _, data := range self.pending.flush("") {
+    data.To = uid
+    conn.WriteChan <- data
+  }
+  if self.onConnect != nil {
+    self.onConnect(uid)
+  }
+}
+
+// handshakeTimeout 重连后等待IDENTITY握手完成的最长时间，超时视为本次连接不可信
+const handshakeTimeout = 5 * time.Second
+
+// reconnectSupervisor 消费closeConn投递的断线通知，为每个断连节点单独拉起一轮重连
+func (self *TP) reconnectSupervisor() {
+  for uid := range self.disconnected {
+    go self.redial(uid)
+  }
+}
+
+// redial 按self.reconnect的退避策略重试连接服务端，直至成功、TP已关闭或达到
+// MaxAttempts；新socket建立后必须先通过与新连接时相同的IDENTITY校验才会被信任，
+// 校验通过（connPool.rename为uid且标记就绪）后才补发掉线期间积压的待发消息——
+// 不会像直接SetReady那样盲信新socket另一端声称的身份
+func (self *TP) redial(uid string) {
+  for attempt := 0; self.reconnect.MaxAttempts <= 0 || attempt < self.reconnect.MaxAttempts; attempt++ {
+    if self.closing.isSet() || self.canClose {
+      return
+    }
+    time.Sleep(self.reconnect.delay(attempt))
+
+    conn, err := self.dial()
+    if err != nil {
+      log.Printf(" *     —— 第%v次重连服务端失败：%v ——", attempt+1, err)
+      continue
+    }
+
+    rawKey := conn.RemoteAddr().String()
+    wrapped := NewConnect(conn, self.connWChanCap, self.connBufferLen)
+    wrapped.UID = rawKey
+    self.connPool.set(rawKey, wrapped)
+    go self.readLoop(wrapped)
+    go self.writeLoop(wrapped)
+
+    // 由本端主动发起IDENTITY挑战（走与save()收到真实请求完全相同的apiHandle/
+    // identity.Process路径），而不是信任新socket另一端未经校验的自报身份
+    self.apiReadChan <- NewNetData("", rawKey, IDENTITY, nil)
+
+    if self.waitHandshake(uid, handshakeTimeout) {
+      log.Printf(" *     —— 与节点 %v 重连成功！——", uid)
+      return
+    }
+    log.Printf(" *     —— 节点 %v 重连后握手校验失败或超时，放弃本次连接 ——", uid)
+  }
+  log.Printf(" *     —— 节点 %v 超过最大重试次数，放弃重连 ——", uid)
+}
+
+// waitHandshake 轮询等待uid对应连接完成IDENTITY校验并标记就绪；identity.Process
+// 在校验失败时会直接closeConn，校验成功时会将连接从rawKey重命名为claimedUID并
+// flushPending，因此超时或uid不匹配都意味着本次连接不可信
+func (self *TP) waitHandshake(uid string, timeout time.Duration) bool {
+  deadline := time.Now().Add(timeout)
+  for time.Now().Before(deadline) {
+    if conn, ok := self.connPool.get(uid); ok && conn.IsReady() {
+      return true
+    }
+    time.Sleep(20 * time.Millisecond)
+  }
+  return false
+}
+
+// dial 按当前TLS配置拨号连接服务端，供redial重建断开的长连接使用
+func (self *TP) dial() (net.Conn, error) {
+  addr := self.serverAddr + self.port
+  if conf := self.effectiveTLSConfig(); conf != nil {
+    return tls.Dial("tcp", addr, conf)
+  }
+  return net.Dial("tcp", addr)
+}
+
+// pendingQueue 按目标uid缓存客户端掉线期间积压的待发消息，重连成功后统一flush
+type pendingQueue struct {
+  mu       sync.Mutex
+  capacity int
+  messages map[string][]*NetData
+}
+
+func newPendingQueue(capacity int) *pendingQueue {
+  return &pendingQueue{capacity: capacity, messages: make(map[string][]*NetData)}
+}
+
+// push 将消息追加到目标uid的待发队列，超出容量时丢弃最旧的一条
+func (self *pendingQueue) push(uid string, data *NetData) {
+  self.mu.Lock()
+  defer self.mu.Unlock()
+  q := self.messages[uid]
+  if len(q) >= self.capacity {
+    q = q[1:]
+  }
+  self.messages[uid] = append(q, data)
+}
+
+// flush 取出并清空目标uid的全部待发消息
+func (self *pendingQueue) flush(uid string) []*NetData {
+  self.mu.Lock()
+  defer self.mu.Unlock()
+  q := self.messages[uid]
+  delete(self.messages, uid)
+  return q
+}
@@ -0,0 +1,58 @@
+package teleport
+
+import (
+  "net"
+  "strings"
+  "testing"
+)
+
+// TestRedialRejectsWrongAuthKey验证重连对端若以错误的预共享密钥参与IDENTITY
+// 握手，redial不会将其提升为就绪连接——不能重演chunk0-4修复前"盲信新socket
+// 自报身份"的那个洞
+func TestRedialRejectsWrongAuthKey(t *testing.T) {
+  ln, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatalf("监听本地端口失败：%v", err)
+  }
+  defer ln.Close()
+
+  // 伪造的服务端：使用与客户端不同的预共享密钥，IDENTITY握手中产出的Proof
+  // 必然无法通过客户端的verifyHandshake校验
+  srv := New().(*TP)
+  srv.uid = "server1"
+  srv.authKey = []byte("wrong-key")
+  srv.reserveAPI()
+  go srv.apiHandle()
+
+  go func() {
+    for {
+      conn, err := ln.Accept()
+      if err != nil {
+        return
+      }
+      rawKey := conn.RemoteAddr().String()
+      wrapped := NewConnect(conn, srv.connWChanCap, srv.connBufferLen)
+      wrapped.UID = rawKey
+      srv.connPool.set(rawKey, wrapped)
+      go srv.readLoop(wrapped)
+      go srv.writeLoop(wrapped)
+    }
+  }()
+
+  cli := New().(*TP)
+  cli.uid = "client1"
+  cli.authKey = []byte("secret-key")
+  cli.reserveAPI()
+  cli.serverAddr = "127.0.0.1"
+  cli.port = ln.Addr().String()[strings.LastIndex(ln.Addr().String(), ":"):]
+  cli.reconnect = &ReconnectPolicy{Base: 10e6, Factor: 1, MaxDelay: 10e6, MaxAttempts: 1}
+  go cli.apiHandle()
+
+  // redial内部会等满handshakeTimeout才放弃一次无法完成校验的连接，直接同步调用
+  // 即可验证其最终不会把这条连接提升为就绪
+  cli.redial("server1")
+
+  if conn, ok := cli.connPool.get("server1"); ok && conn.IsReady() {
+    t.Fatal("预共享密钥不一致时，redial本不应将连接提升为就绪")
+  }
+}
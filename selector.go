@@ -0,0 +1,84 @@
+// 节点选择策略：Request在未显式指定nodeuid时，以及服务端回复To为空时，据此挑选目标连接。
+package teleport
+
+import (
+  "hash/fnv"
+  "math/rand"
+  "sync/atomic"
+)
+
+// Selector 从就绪连接中挑选一个目标，key通常为operation，ConsistentHashSelector场景下
+// 由调用方改传NetData.RouteKey
+type Selector interface {
+  Pick(key string, pool []*Connect) *Connect
+}
+
+// SetSelector 替换默认的节点选择策略，默认为RandomSelector
+func (self *TP) SetSelector(selector Selector) Teleport {
+  self.selector = selector
+  return self
+}
+
+// RandomSelector 在就绪连接中随机选取一个，与原先遍历map时map迭代顺序的随机性等价
+type RandomSelector struct{}
+
+func (*RandomSelector) Pick(key string, pool []*Connect) *Connect {
+  if len(pool) == 0 {
+    return nil
+  }
+  return pool[rand.Intn(len(pool))]
+}
+
+// RoundRobinSelector 在就绪连接间轮流选取，保证各节点接收请求数大致均衡
+type RoundRobinSelector struct {
+  next uint64
+}
+
+func (self *RoundRobinSelector) Pick(key string, pool []*Connect) *Connect {
+  if len(pool) == 0 {
+    return nil
+  }
+  i := atomic.AddUint64(&self.next, 1)
+  return pool[int(i)%len(pool)]
+}
+
+// LeastPendingSelector 选取发送缓存通道中积压请求最少的连接，实现简单的负载感知
+type LeastPendingSelector struct{}
+
+func (*LeastPendingSelector) Pick(key string, pool []*Connect) *Connect {
+  var best *Connect
+  for _, conn := range pool {
+    if best == nil || len(conn.WriteChan) < len(best.WriteChan) {
+      best = conn
+    }
+  }
+  return best
+}
+
+// ConsistentHashSelector 依据key做一致性哈希，相同key稳定落到同一节点
+type ConsistentHashSelector struct{}
+
+func (*ConsistentHashSelector) Pick(key string, pool []*Connect) *Connect {
+  if len(pool) == 0 {
+    return nil
+  }
+  h := fnv.New32a()
+  h.Write([]byte(key))
+  return pool[int(h.Sum32())%len(pool)]
+}
+
+// pickNode 基于已配置的Selector，从当前就绪连接中选择一个目标节点；routeKey非空时优先
+// 于operation参与哈希，供ConsistentHashSelector使用
+func (self *TP) pickNode(operation, routeKey string) (string, bool) {
+  pool := self.connPool.readyConns()
+
+  key := operation
+  if routeKey != "" {
+    key = routeKey
+  }
+  conn := self.selector.Pick(key, pool)
+  if conn == nil {
+    return "", false
+  }
+  return conn.UID, true
+}
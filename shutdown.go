@@ -0,0 +1,69 @@
+// 优雅关闭：与Close()的硬中断不同，Shutdown等待在途请求处理完毕并flush发送队列。
+package teleport
+
+import (
+  "context"
+  "log"
+  "sync/atomic"
+)
+
+// closeFlag 是一个原子的一次性关闭标志
+type closeFlag int32
+
+func (self *closeFlag) set() {
+  atomic.StoreInt32((*int32)(self), 1)
+}
+
+func (self *closeFlag) isSet() bool {
+  return atomic.LoadInt32((*int32)(self)) == 1
+}
+
+// Shutdown 优雅关闭：停止监听、停止向apiReadChan投递新请求、等待在途请求处理完毕
+// （或ctx超时），flush各连接的发送队列后再关闭全部连接。与Close()直接丢弃在途
+// 请求和待发消息不同，Shutdown尽力保证已接收的请求得到处理、已产生的回复得到发送
+func (self *TP) Shutdown(ctx context.Context) error {
+  self.closing.set()
+
+  if self.listener != nil {
+    self.listener.Close()
+  }
+
+  done := make(chan struct{})
+  go func() {
+    self.inflight.Wait()
+    close(done)
+  }()
+
+  select {
+  case <-done:
+  case <-ctx.Done():
+  }
+
+  // send依据data.To在connPool中查找连接，因此先在连接仍登记时flush，再清空连接表
+  for _, uid := range self.connPool.nodes() {
+    if conn, ok := self.connPool.get(uid); ok {
+      self.flushWriteChan(conn)
+    }
+  }
+  for uid, conn := range self.connPool.drain() {
+    conn.Close()
+    log.Printf(" *     —— 与节点 %v 优雅断开连接！——", uid)
+  }
+
+  return ctx.Err()
+}
+
+// flushWriteChan 在关闭连接前，尽力发送其发送通道中已积压但尚未写出的数据
+func (self *TP) flushWriteChan(conn *Connect) {
+  for {
+    select {
+    case data, ok := <-conn.WriteChan:
+      if !ok {
+        return
+      }
+      self.send(data)
+    default:
+      return
+    }
+  }
+}
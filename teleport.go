@@ -3,11 +3,12 @@
 package teleport
 
 import (
+  "context"
+  "crypto/tls"
   "log"
   "net"
+  "sync"
   "time"
-
-  "github.com/golang/protobuf/proto"
 )
 
 // mode
@@ -19,7 +20,8 @@ const (
 
 // API中定义操作时必须保留的字段
 const (
-  // 身份登记
+  // 身份登记，Body应为*HandshakeRequest；握手由identity.Process经apiHandle完成，
+  // 校验失败的连接在提升为UID键之前即被关闭，未就绪连接上的非IDENTITY请求由save()丢弃、不进入apiHandle
   IDENTITY = "+identity+"
   // 心跳操作符
   HEARTBEAT = "+heartbeat+"
@@ -34,7 +36,33 @@ type Teleport interface {
   Request(body interface{}, operation string, nodeuid ...string)
   // 指定自定义的应用程序API
   SetAPI(api API) Teleport
-  // 断开连接，参数为空则断开所有连接，服务器模式下还将停止监听
+  // 设置消息编解码器，默认为JSONCodec，与对端约定好即可替换为Gob
+  SetCodec(codec Codec) Teleport
+  // 设置粘包/拆包分帧策略，默认为LengthPrefixFramer
+  SetFramer(framer Framer) Teleport
+  // 注册中间件，包裹在各API的Process外层，默认已包含panic恢复
+  Use(mws ...Middleware) Teleport
+  // 设置TLS配置，server()/client()据此改用密文传输，为nil则保持明文socket
+  SetTLSConfig(conf *tls.Config) Teleport
+  // 设置IDENTITY握手所使用的预共享密钥，为空则不校验对端身份（历史行为）
+  SetAuthKey(key string) Teleport
+  // 设置CLIENT模式下的断线重连策略，短连接模式不参与重连
+  SetReconnect(policy *ReconnectPolicy) Teleport
+  // 注册连接（含重连成功）就绪后触发的回调
+  OnConnect(fn func(uid string)) Teleport
+  // 注册连接断开后触发的回调
+  OnDisconnect(fn func(uid string)) Teleport
+  // 阻塞直到至少有一个就绪连接，或ctx被取消/超时
+  WaitReady(ctx context.Context) error
+  // 设置未显式指定nodeuid时的节点选择策略，默认为RandomSelector
+  SetSelector(selector Selector) Teleport
+  // 返回当前连接池中全部节点uid的快照
+  Nodes() []string
+  // 返回指定uid对应的连接对象
+  Conn(uid string) (*Connect, bool)
+  // 优雅关闭：停止接收新请求，等待在途请求处理完毕（或ctx超时），flush发送队列后关闭全部连接
+  Shutdown(ctx context.Context) error
+  // 断开连接，参数为空则断开所有连接，服务器模式下还将停止监听（不等待在途请求，见Shutdown）
   Close(nodeuid ...string)
 
   // 设置客户端唯一标识符，默认为本节点ip:port，对服务器模式无效，服务器模式的UID强制为“Server”
@@ -69,12 +97,13 @@ type TP struct {
   listener net.Listener
   // 客户端模式下，控制是否为短链接
   canClose bool
-  // 长连接池，刚一连接时key为host:port形式，随后通过身份验证替换为UID
-  connPool map[string]*Connect
+  // 长连接池，刚一连接时key为host:port形式，随后通过身份验证替换为UID；
+  // 并发安全，可被apiHandle、accept循环、心跳协程与Close同时访问
+  connPool *ConnPool
   // 连接时长，心跳时长的依据
   timeout time.Duration
-  // 粘包处理
-  *Protocol
+  // 粘包/拆包处理策略
+  framer Framer
   // 全局接收缓存通道
   apiReadChan chan *NetData
   // 每个连接对象的发送缓存通道长度
@@ -83,6 +112,30 @@ type TP struct {
   connBufferLen int
   // 应用程序API
   api API
+  // 消息编解码器，默认为JSONCodec
+  codec Codec
+  // 包裹在api.Process外层的中间件链，默认已包含RecoverMiddleware
+  middlewares []Middleware
+  // TLS配置，非nil时server()/client()升级为密文传输
+  tlsConfig *tls.Config
+  // IDENTITY握手所使用的预共享密钥，为空则不校验对端身份
+  authKey []byte
+  // CLIENT模式下的断线重连策略，为nil则不自动重连
+  reconnect *ReconnectPolicy
+  // 连接就绪（含重连成功）时触发
+  onConnect func(uid string)
+  // 连接断开时触发
+  onDisconnect func(uid string)
+  // 节点掉线期间积压的待发消息，重连成功后自动flush
+  pending *pendingQueue
+  // CLIENT模式下由closeConn投递需要重连的节点uid，由reconnectSupervisor消费
+  disconnected chan string
+  // 未显式指定nodeuid时的节点选择策略，默认为RandomSelector
+  selector Selector
+  // Shutdown被调用后置位，save()停止向apiReadChan投递新请求，apiHandle停止派发新处理
+  closing closeFlag
+  // 在途请求计数，Shutdown据此等待所有已派发的处理协程退出
+  inflight sync.WaitGroup
 }
 
 // 每个API方法需判断stutas状态，并做相应处理
@@ -96,12 +149,16 @@ type Handle interface {
 // 创建接口实例，0为默认设置
 func New() Teleport {
   return &TP{
-    connPool:      make(map[string]*Connect),
+    connPool:      newConnPool(),
     api:           API{},
-    Protocol:      NewProtocol("henrylee2cn"),
+    framer:        NewLengthPrefixFramer(0),
     apiReadChan:   make(chan *NetData, 4096),
     connWChanCap:  2048,
     connBufferLen: 1024,
+    codec:         new(JSONCodec),
+    middlewares:   []Middleware{RecoverMiddleware()},
+    pending:       newPendingQueue(1024),
+    selector:      new(RandomSelector),
   }
 }
 
@@ -113,6 +170,12 @@ func (self *TP) SetAPI(api API) Teleport {
   return self
 }
 
+// 设置消息编解码器，默认为JSONCodec，与对端约定好即可替换为Gob
+func (self *TP) SetCodec(codec Codec) Teleport {
+  self.codec = codec
+  return self
+}
+
 // 启动服务器模式
 func (self *TP) Server(port string) {
   self.reserveAPI()
@@ -140,36 +203,33 @@ func (self *TP) Client(serverAddr string, port string, isShort ...bool) {
   self.port = port
   self.serverAddr = serverAddr
 
+  if self.reconnect != nil {
+    self.disconnected = make(chan string, 16)
+    go self.reconnectSupervisor()
+  }
+
   go self.apiHandle()
   go self.client()
 }
 
-// *主动推送信息，直到有连接出现开始发送，不写nodeuid默认随机发送给一个节点
+// *主动推送信息，不写nodeuid默认随机发送给一个节点；目标节点当前不可用时，
+// 消息会先存入待发队列，待其（重新）连接就绪后由flushPending自动补发；未指定nodeuid
+// 且当时没有就绪节点时，消息由首个就绪的连接补发
 func (self *TP) Request(body interface{}, operation string, nodeuid ...string) {
-  var conn *Connect
   var uid string
-  if len(nodeuid) == 0 {
-    for {
-      if self.CountNodes() > 0 {
-        break
-      }
-      time.Sleep(5e8)
-    }
-    // 一个随机节点的信息
-    for uid, conn = range self.connPool {
-      if conn.IsReady() {
-        nodeuid = append(nodeuid, uid)
-        break
-      }
-    }
+  if len(nodeuid) > 0 {
+    uid = nodeuid[0]
+  } else {
+    uid, _ = self.pickNode(operation, "")
   }
-  // 等待并取得连接实例
-  conn = self.getConn(nodeuid[0])
-  for conn == nil || !conn.IsReady() {
-    conn = self.getConn(nodeuid[0])
-    time.Sleep(5e8)
+
+  data := NewNetData(self.uid, uid, operation, body)
+  conn := self.getConn(uid)
+  if conn == nil || !conn.IsReady() {
+    self.pending.push(uid, data)
+    return
   }
-  conn.WriteChan <- NewNetData(self.uid, nodeuid[0], operation, body)
+  conn.WriteChan <- data
   // log.Println("添加一条请求：", conn.RemoteAddr().String(), operation, body)
 }
 
@@ -181,16 +241,14 @@ func (self *TP) Close(nodeuid ...string) {
   }
   self.canClose = true
   if len(nodeuid) == 0 {
-    for uid, conn := range self.connPool {
+    for uid, conn := range self.connPool.drain() {
       log.Printf(" *     —— 与节点 %v (%v) 断开连接！——", uid, conn.UID)
       conn.Close()
-      delete(self.connPool, uid)
     }
     return
   }
   for _, uid := range nodeuid {
-    self.connPool[uid].Close()
-    delete(self.connPool, uid)
+    self.closeConn(uid)
   }
 }
 
@@ -203,9 +261,18 @@ func (self *TP) SetUID(nodeuid string) Teleport {
   return self
 }
 
-// 设置包头字符串，默认为henrylee2cn
+// 设置包头字符串，默认为空（不校验）。仅当当前Framer为LengthPrefixFramer时生效，
+// 供需要协议探测魔数的场景使用
 func (self *TP) SetPackHeader(header string) Teleport {
-  self.Protocol.ReSet(header)
+  if framer, ok := self.framer.(*LengthPrefixFramer); ok {
+    framer.Header = header
+  }
+  return self
+}
+
+// 设置粘包/拆包分帧策略，默认为LengthPrefixFramer
+func (self *TP) SetFramer(framer Framer) Teleport {
+  self.framer = framer
   return self
 }
 
@@ -240,35 +307,35 @@ func (self *TP) GetMode() int {
 
 // 返回当前有效连接节点数
 func (self *TP) CountNodes() int {
-  count := 0
-  for _, conn := range self.connPool {
-    if conn.IsReady() {
-      count++
-    }
-  }
-  return count
+  return self.connPool.count(true)
+}
+
+// Nodes 返回当前连接池中全部节点uid的快照
+func (self *TP) Nodes() []string {
+  return self.connPool.nodes()
+}
+
+// Conn 返回指定uid对应的连接对象
+func (self *TP) Conn(uid string) (*Connect, bool) {
+  return self.connPool.get(uid)
 }
 
 // ***********************************************公用方法*************************************************** \\
 
 func (self *TP) read(conn *Connect) bool {
-  read_len, err := conn.Read(conn.Buffer)
+  frame, err := self.framer.ReadFrame(conn.reader)
   if err != nil {
     log.Println(err)
     return false
   }
-
-  if read_len == 0 {
-    return false // connection already closed by client
-  }
-  conn.TmpBuffer = append(conn.TmpBuffer, conn.Buffer[:read_len]...)
-  self.save(conn)
+  self.save(conn, frame)
   return true
 }
 
 // 根据uid获取连接对象
 func (self *TP) getConn(nodeuid string) *Connect {
-  return self.connPool[nodeuid]
+  conn, _ := self.connPool.get(nodeuid)
+  return conn
 }
 
 // 根据uid获取连接对象地址
@@ -283,13 +350,36 @@ func (self *TP) getConnAddr(nodeuid string) string {
 
 // 关闭连接，退出协程
 func (self *TP) closeConn(nodeuid string) {
-  conn := self.connPool[nodeuid]
-  if conn == nil {
+  conn, ok := self.connPool.get(nodeuid)
+  if !ok {
     return
   }
   log.Printf(" *     —— 与节点 %v (%v) 断开连接！——", nodeuid, conn.RemoteAddr().String())
   conn.Close()
-  delete(self.connPool, nodeuid)
+  self.connPool.delete(nodeuid)
+  self.notifyDisconnect(nodeuid)
+  if self.shouldReconnect() {
+    select {
+    case self.disconnected <- nodeuid:
+    default:
+      // 通道（所有节点共用）已满时不阻塞，丢弃本次通知，该节点不会因这次断开被重连
+    }
+  }
+}
+
+// readLoop 持续从连接读取帧并解码投递，读失败（对端断开）时关闭连接，
+// CLIENT长连接模式下由closeConn触发后续的自动重连
+func (self *TP) readLoop(conn *Connect) {
+  for self.read(conn) {
+  }
+  self.closeConn(conn.UID)
+}
+
+// writeLoop 持续消费连接的发送通道并写出，通道随连接一起Close后退出
+func (self *TP) writeLoop(conn *Connect) {
+  for data := range conn.WriteChan {
+    self.send(data)
+  }
 }
 
 // 通信数据编码与发送
@@ -298,22 +388,7 @@ func (self *TP) send(data *NetData) {
     data.From = self.uid
   }
 
-  var protoNetData NetDataProto
-  switch data.Body.(type) {
-  case string:
-    protoNetData.Body = []byte(data.Body.(string))
-  default:
-    protoNetData.Body = data.Body.([]byte)
-  }
-
-  protoNetData.Operation = proto.String(data.Operation)
-  protoNetData.UID = proto.String(data.UID)
-  protoNetData.From = proto.String(data.From)
-  protoNetData.To = proto.String(data.To)
-  protoNetData.Status = proto.Int64(int64(data.Status))
-
-  d, err := proto.Marshal(&protoNetData)
-  // d, err := json.Marshal(*data)
+  d, err := self.codec.Marshal(data)
   if err != nil {
     log.Println("编码出错了", err)
     return
@@ -324,52 +399,59 @@ func (self *TP) send(data *NetData) {
     // log.Println("发送信息失败：", data)
     return
   }
-  // 封包
-  end := self.Packet(d)
-  // 发送
-  conn.Write(end)
+  // 包头附带一字节编解码器标识，供对端按标识选择解码方式
+  payload := append([]byte{codecTag(self.codec)}, d...)
+  // 封帧并发送
+  if err := self.framer.WriteFrame(conn, payload); err != nil {
+    log.Println("发送出错了", err)
+  }
   // log.Println("成功发送一条信息：", data)
 }
 
-// 解码收到的数据并存入缓存
-func (self *TP) save(conn *Connect) {
-  // 解包
-  dataSlice := make([][]byte, 10)
-  dataSlice, conn.TmpBuffer = self.Unpack(conn.TmpBuffer)
-  for _, data := range dataSlice {
-    // js := map[string]interface{}{}
-    // json.Unmarshal(data, &js)
-    // log.Printf("接收信息为：%v", js)
-    d := new(NetData)
-    protoNetData := new(NetDataProto)
-    if err := proto.Unmarshal(data, protoNetData); err == nil {
-      // if err := json.Unmarshal(data, d); err == nil {
-      // 修复缺失请求方地址的请求
-      if d.From == "" {
-        d.From = conn.UID
-      }
-
-      d.Body = protoNetData.GetBody()
-      d.Operation = protoNetData.GetOperation()
-      d.UID = protoNetData.GetUID()
-      d.From = protoNetData.GetFrom()
-      d.To = protoNetData.GetTo()
-      d.Status = int(protoNetData.GetStatus())
-
-      // 添加到读取缓存
-      self.apiReadChan <- d
-      // log.Printf("接收信息为：%v", d)
-    } else {
-      log.Println(err)
-    }
+// 解码收到的一帧数据并存入缓存
+func (self *TP) save(conn *Connect, frame []byte) {
+  if self.closing.isSet() {
+    // Shutdown进行中，不再接收新请求
+    return
+  }
+  if len(frame) == 0 {
+    return
+  }
+  // 首字节为编解码器标识，其余为编码后的数据
+  d, err := codecByTag(frame[0]).Unmarshal(frame[1:])
+  if err != nil {
+    log.Println(err)
+    return
+  }
+  // 修复缺失请求方地址的请求
+  if d.From == "" {
+    d.From = conn.UID
+  }
+  // To在对端本地connPool中的命名空间与本端不同，对本端而言唯一有意义的只是
+  // "这条消息是从本端哪个连接收到的"——统一改写为该连接在本端connPool中的键，
+  // 而不是信任对端写入的、对本端没有意义的原始To，否则apiHandle后续依据To
+  // 回信/探测短连接关闭时会在错误的（甚至不存在的）键上查找连接
+  d.To = conn.UID
+  // 未完成身份识别的连接只允许走IDENTITY握手，其余请求一律丢弃，不进入apiHandle
+  if !conn.IsReady() && d.Operation != IDENTITY {
+    return
   }
+  // 添加到读取缓存
+  self.apiReadChan <- d
+  // log.Printf("接收信息为：%v", d)
 }
 
 // 使用API并发处理请求
 func (self *TP) apiHandle() {
   for {
     req := <-self.apiReadChan
+    if self.closing.isSet() {
+      // Shutdown进行中，不再派发新的处理
+      continue
+    }
+    self.inflight.Add(1)
     go func(req *NetData) {
+      defer self.inflight.Done()
       var conn *Connect
 
       operation, from, to := req.Operation, req.To, req.From
@@ -382,7 +464,7 @@ func (self *TP) apiHandle() {
         return
       }
 
-      resp := handle.Process(req)
+      resp := self.wrapHandle(handle).Process(req)
       if resp == nil {
         if conn = self.getConn(to); conn != nil && self.getConn(to).IsShort {
           self.closeConn(to)
@@ -391,7 +473,12 @@ func (self *TP) apiHandle() {
       }
 
       if resp.To == "" {
-        resp.To = to
+        // 默认返回原请求端；原请求端地址缺失（如主动推送场景）时借助Selector挑选目标
+        if to != "" {
+          resp.To = to
+        } else {
+          resp.To, _ = self.pickNode(operation, resp.RouteKey)
+        }
       }
 
       // 若指定节点连接不存在，则向原请求端返回错误
@@ -429,19 +516,71 @@ func (self *TP) autoErrorHandle(data *NetData, status int, msg string, reqFrom s
 
 // 强制设定系统保留的API
 func (self *TP) reserveAPI() {
-  // 添加保留规则——身份识别
-  self.api[IDENTITY] = identi
+  // 添加保留规则——身份识别，绑定自身实例以访问连接池与握手密钥
+  self.api[IDENTITY] = &identity{tp: self}
   // 添加保留规则——忽略心跳请求
   self.api[HEARTBEAT] = beat
 }
 
-var identi, beat = new(identity), new(heartbeat)
+var beat = new(heartbeat)
+
+// identity 实现三步HMAC挑战/应答握手（IDENTITY被同时注册在双方，identity.Process
+// 对哪一端先发起请求是对称的）：
+// 1) 收到不含Nonce/Proof的请求，视为对端请求本端对其发起挑战：签发一次性nonce并
+//    回送，此时本端扮演校验方，连接仍保持未就绪；
+// 2) 收到带Nonce但不含Proof的请求，视为对端向本端发起的挑战：基于预共享密钥计算
+//    Proof并回送本端真实uid作为身份声明，不在此步提升连接；
+// 3) 收到带Proof的请求，视为对此前本端签发的nonce的应答：校验通过才将连接从
+//    host:port键提升为UID键、标记就绪并补发积压消息，校验失败则直接关闭socket；
+//    无论成败都无需再应答对端，返回nil——对端并不会借由这条连接的这一次握手被
+//    提升为就绪，它若也需要校验本端则应反向发起独立的一轮挑战
+type identity struct {
+  tp *TP
+}
+
+func (self *identity) Process(receive *NetData) *NetData {
+  rawKey := receive.To
+  conn := self.tp.getConn(rawKey)
+  if conn == nil {
+    return nil
+  }
 
-type identity struct{}
+  req := asHandshakeRequest(receive.Body)
+
+  switch {
+  case req == nil || (req.Nonce == "" && req.Proof == ""):
+    // 第一步：对端请求本端发起挑战，签发nonce并回送。To显式指定为rawKey（而非
+    // 留空交给apiHandle按req.From回填）：apiHandle的通用回填约定面向已完成身份
+    // 识别、connPool已按真实UID登记的连接，在身份识别完成前并不适用
+    conn.pendingNonce = self.tp.newNonce()
+    receive.From, receive.To = self.tp.uid, rawKey
+    receive.Body = &HandshakeRequest{Nonce: conn.pendingNonce}
+    return receive
+
+  case req.Proof == "":
+    // 第二步：收到对端下发的挑战nonce，基于预共享密钥对本端真实uid计算Proof并
+    // 回送，声明的身份是self.tp.uid而非本端对这条连接的本地记账键；To同样显式
+    // 指定为rawKey，理由同第一步
+    receive.From, receive.To = self.tp.uid, rawKey
+    receive.Body = &HandshakeRequest{Proof: self.tp.signHandshake(self.tp.uid, req.Nonce)}
+    return receive
 
-func (*identity) Process(receive *NetData) *NetData {
-  receive.From, receive.To = receive.To, receive.From
-  return receive
+  default:
+    // 第三步：校验对端基于本端此前签发的nonce计算的Proof
+    if !self.tp.verifyHandshake(receive.From, conn.pendingNonce, req.Proof) {
+      log.Printf(" *     —— 节点 %v 身份握手校验失败，断开连接 ——", receive.From)
+      self.tp.closeConn(rawKey)
+      return nil
+    }
+
+    claimedUID := receive.From
+    conn.UID = claimedUID
+    conn.pendingNonce = ""
+    conn.SetReady()
+    self.tp.connPool.rename(rawKey, claimedUID)
+    self.tp.flushPending(claimedUID, conn)
+    return nil
+  }
 }
 
 type heartbeat struct{}